@@ -30,7 +30,10 @@ import (
 
 type oioLogger interface {
 	close()
-	writeAccess(message string)
+	// writeAccess enqueues/writes an access log line and reports whether
+	// it was actually accepted, so callers can account for drops caused
+	// by a saturated backend (e.g. SysLogger's bounded queue).
+	writeAccess(message string) bool
 	writeInfo(message string)
 	writeError(message string)
 }
@@ -100,6 +103,10 @@ type LogRequestTemplateInventory struct {
 	Path     string
 	TLS      bool
 	Message  string
+	// Fields carries the typed key/value pairs attached through the
+	// FieldLogger API (rr.Log().WithField(...)), on top of the free-text
+	// Message. It is nil when no field was attached.
+	Fields map[string]interface{}
 }
 
 func InitLogTemplates() error {
@@ -127,22 +134,30 @@ func severityAllowed(severity syslog.Priority) bool {
 func initVerbosity(severity syslog.Priority) {
 	logDefaultSeverity = severity
 	logSeverity = severity
+	verbosity.SetDefault(0)
 }
 
+// maximizeVerbosity raises both the legacy logSeverity threshold and the
+// VerbosityFilter default, so LogV/LogRequestV (which bypass logSeverity
+// entirely and gate solely on the filter) are unlocked the same way
+// LogDebug used to be.
 func maximizeVerbosity() {
 	logExtremeVerbosity = true
 	logDefaultSeverity = syslog.LOG_DEBUG
 	logSeverity = syslog.LOG_DEBUG
+	verbosity.SetDefault(maxVerbosityLevel)
 }
 
 func increaseVerbosity() {
 	if logSeverity < syslog.LOG_DEBUG {
 		logSeverity = logSeverity + 1
+		verbosity.SetDefault(verbosity.Default() + 1)
 	}
 }
 
 func resetVerbosity() {
 	logSeverity = logDefaultSeverity
+	verbosity.SetDefault(0)
 }
 
 func getSeverity(priority syslog.Priority) (bool, string) {
@@ -162,16 +177,28 @@ func writeLogFmt(pri syslog.Priority, format string, v ...interface{}) {
 	if !severityAllowed(pri) {
 		return
 	}
+	writeLogFmtForced(pri, format, v...)
+}
+
+// writeLogFmtForced formats and dispatches format/v unconditionally,
+// skipping the severityAllowed gate. It backs LogV, whose own V(level)
+// check against the vmodule filter already decided the line is wanted:
+// the global logSeverity threshold must not veto it again, or vmodule
+// could only ever lower verbosity, never raise it above the global level.
+func writeLogFmtForced(pri syslog.Priority, format string, v ...interface{}) {
 	erroneous, severityName := getSeverity(pri)
 
-	var output bytes.Buffer
-	if logTemplate != nil {
-		err := logTemplate.Execute(&output, LogTemplateInventory{
-			Pid:      pid,
-			Severity: severityName,
-			Message:  fmt.Sprintf(format, v...),
-		})
+	inv := LogTemplateInventory{
+		Pid:      pid,
+		Severity: severityName,
+		Message:  fmt.Sprintf(format, v...),
+	}
 
+	var output bytes.Buffer
+	if logOutputFormat != logRecordText {
+		output.WriteString(buildLogFields(inv).encode(logOutputFormat))
+	} else if logTemplate != nil {
+		err := logTemplate.Execute(&output, inv)
 		if err != nil {
 			log.Printf("Error while executing logTemplate: %v", err)
 			return
@@ -205,55 +232,72 @@ func LogInfo(format string, v ...interface{}) {
 	writeLogFmt(syslog.LOG_INFO, format, v...)
 }
 
-func LogDebug(format string, v ...interface{}) {
-	writeLogFmt(syslog.LOG_DEBUG, format, v...)
+func writeLogRequestFmt(rr *rawxRequest, pri syslog.Priority, format string, v ...interface{}) {
+	writeLogRequestFmtFields(rr, pri, nil, format, v...)
 }
 
-func writeLogRequestFmt(rr *rawxRequest, pri syslog.Priority, format string, v ...interface{}) {
+// writeLogRequestFmtFields is the core of the request log path, shared by
+// the plain LogRequest* helpers (fields == nil) and the FieldLogger API.
+func writeLogRequestFmtFields(rr *rawxRequest, pri syslog.Priority, fields map[string]interface{}, format string, v ...interface{}) {
 	if !severityAllowed(pri) {
 		return
 	}
+	writeLogRequestFmtFieldsForced(rr, pri, fields, format, v...)
+}
+
+// writeLogRequestFmtFieldsForced formats and dispatches unconditionally,
+// skipping the severityAllowed gate. See writeLogFmtForced for why
+// LogRequestV needs this.
+func writeLogRequestFmtFieldsForced(rr *rawxRequest, pri syslog.Priority, fields map[string]interface{}, format string, v ...interface{}) {
 	erroneous, severityName := getSeverity(pri)
 
-	var output bytes.Buffer
-	if logRequestTemplate != nil {
-		var local string
-		var peer string
-		var method string
-		var reqId string
-		var path string
-		var TLS bool
-		if rr != nil {
-			local = rr.req.Host
-			peer = rr.req.RemoteAddr
-			method = rr.req.Method
-			reqId = rr.reqid
-			path = rr.req.URL.Path
-			TLS = rr.req.TLS != nil
-		} else {
-			local = ""
-			peer = ""
-			method = ""
-			reqId = ""
-			path = ""
-			TLS = false
-		}
-		err := logRequestTemplate.Execute(&output, LogRequestTemplateInventory{
-			Pid:      pid,
-			Severity: severityName,
-			Local:    local,
-			Peer:     peer,
-			Method:   method,
-			ReqId:    reqId,
-			Path:     path,
-			TLS:      TLS,
-			Message:  fmt.Sprintf(format, v...),
-		})
+	var local string
+	var peer string
+	var method string
+	var reqId string
+	var path string
+	var TLS bool
+	if rr != nil {
+		local = rr.req.Host
+		peer = rr.req.RemoteAddr
+		method = rr.req.Method
+		reqId = rr.reqid
+		path = rr.req.URL.Path
+		TLS = rr.req.TLS != nil
+	} else {
+		local = ""
+		peer = ""
+		method = ""
+		reqId = ""
+		path = ""
+		TLS = false
+	}
+	inv := LogRequestTemplateInventory{
+		Pid:      pid,
+		Severity: severityName,
+		Local:    local,
+		Peer:     peer,
+		Method:   method,
+		ReqId:    reqId,
+		Path:     path,
+		TLS:      TLS,
+		Message:  fmt.Sprintf(format, v...),
+		Fields:   fields,
+	}
 
+	var output bytes.Buffer
+	if logOutputFormat != logRecordText {
+		output.WriteString(buildLogRequestFields(inv).encode(logOutputFormat))
+	} else if logRequestTemplate != nil {
+		err := logRequestTemplate.Execute(&output, inv)
 		if err != nil {
 			log.Printf("Error while executing logRequestTemplate: %v", err)
 			return
 		}
+		if len(fields) > 0 {
+			output.WriteByte(' ')
+			output.WriteString(encodeFieldsLogfmt(fields))
+		}
 	} else {
 		log.Printf(format, v...)
 		return
@@ -283,12 +327,13 @@ func LogRequestInfo(rr *rawxRequest, format string, v ...interface{}) {
 	writeLogRequestFmt(rr, syslog.LOG_INFO, format, v...)
 }
 
-func LogRequestDebug(rr *rawxRequest, format string, v ...interface{}) {
-	writeLogRequestFmt(rr, syslog.LOG_DEBUG, format, v...)
-}
-
 func (evt AccessLogEvent) String() string {
 	evt.Pid = pid
+
+	if logOutputFormat != logRecordText {
+		return buildAccessLogFields(evt).encode(logOutputFormat)
+	}
+
 	var output bytes.Buffer
 	err := logAccessTemplate.Execute(&output, evt)
 
@@ -299,18 +344,27 @@ func (evt AccessLogEvent) String() string {
 	return output.String()
 }
 
+// LogHttp applies the access log policy's sampling decision and, if the
+// event is kept, hands it to the logger backend. A drop caused by the
+// backend itself being saturated (as opposed to being sampled out) is
+// accounted for in accessStats.
 func LogHttp(evt AccessLogEvent) {
-	logger.writeAccess(evt.String())
+	if !accessPolicy.shouldLog(evt) {
+		return
+	}
+	if !logger.writeAccess(evt.String()) {
+		accessStats.recordDrop(evt)
+	}
 }
 
 func InitNoopLogger() {
 	logger = &NoopLogger{}
 }
 
-func (*NoopLogger) writeAccess(string) {}
-func (*NoopLogger) writeInfo(string)   {}
-func (*NoopLogger) writeError(string)  {}
-func (*NoopLogger) close()             {}
+func (*NoopLogger) writeAccess(string) bool { return true }
+func (*NoopLogger) writeInfo(string)        {}
+func (*NoopLogger) writeError(string)       {}
+func (*NoopLogger) close()                  {}
 
 func InitStderrLogger() {
 	initVerbosity(syslog.LOG_DEBUG)
@@ -325,10 +379,10 @@ func (l *StderrLogger) writeAll(m string) {
 	l.logger.Println(fmt.Sprintf("%v.%06d", now.Unix(), (now.UnixNano()/1000)%1000000), m)
 }
 
-func (l *StderrLogger) writeAccess(m string) { l.writeAll(m) }
-func (l *StderrLogger) writeInfo(m string)   { l.writeAll(m) }
-func (l *StderrLogger) writeError(m string)  { l.writeAll(m) }
-func (l *StderrLogger) close()               {}
+func (l *StderrLogger) writeAccess(m string) bool { l.writeAll(m); return true }
+func (l *StderrLogger) writeInfo(m string)        { l.writeAll(m) }
+func (l *StderrLogger) writeError(m string)       { l.writeAll(m) }
+func (l *StderrLogger) close()                    {}
 
 type SysLogger struct {
 	queue         chan string
@@ -341,6 +395,16 @@ type SysLogger struct {
 	loggerError   *syslog.Writer
 }
 
+// accessBatchMaxEntries and accessBatchMaxDelay bound how long an access
+// line can sit in the batch before being flushed, draining the queue in
+// bursts instead of one receive per line, without adding unbounded
+// latency to any single line. Each entry is still written to syslog as
+// its own Info call (see flush in InitSysLogger).
+const (
+	accessBatchMaxEntries = 256
+	accessBatchMaxDelay   = 10 * time.Millisecond
+)
+
 func InitSysLogger(syslogID string) {
 	initVerbosity(syslog.LOG_INFO)
 	l := &SysLogger{}
@@ -353,23 +417,54 @@ func InitSysLogger(syslogID string) {
 	l.loggerError, _ = syslog.New(syslog.LOG_LOCAL0|syslog.LOG_ERR, syslogID)
 	l.wg.Add(1)
 	go func() {
-		for evt := range l.queue {
-			l.loggerAccess.Info(evt)
+		defer l.wg.Done()
+		batch := make([]string, 0, accessBatchMaxEntries)
+		ticker := time.NewTicker(accessBatchMaxDelay)
+		defer ticker.Stop()
+
+		// flush still emits one syslog.Writer.Info call per entry: Info
+		// frames a single message with its own priority/timestamp/tag
+		// header, so joining lines with "\n" would collapse a whole batch
+		// into one multi-line record and break per-line ingestion.
+		// Batching only amortizes the channel reads and bounds how long
+		// an entry can wait before being written.
+		flush := func() {
+			for _, e := range batch {
+				l.loggerAccess.Info(e)
+			}
+			batch = batch[:0]
+		}
+
+		for {
+			select {
+			case evt, ok := <-l.queue:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, evt)
+				if len(batch) >= accessBatchMaxEntries {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
 		}
-		l.wg.Done()
 	}()
 	logger = l
 }
 
-func (l *SysLogger) writeAccess(m string) {
+func (l *SysLogger) writeAccess(m string) bool {
 	select {
 	case l.queue <- m: // no-blocking call, everything is fine
+		return true
 	default:
 		if l.alertThrottle.Ok() {
 			LogWarning("syslog clogged")
 		}
 		// FIXME(jfs): Uncomment this upon an absolute necessity
 		// l.queue <- m
+		return false
 	}
 }
 
@@ -0,0 +1,187 @@
+// OpenIO SDS Go rawx
+// Copyright (C) 2015-2020 OpenIO SAS
+// Copyright (C) 2021 OVH SAS
+//
+// This library is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Affero General Public
+// License as published by the Free Software Foundation; either
+// version 3.0 of the License, or (at your option) any later version.
+//
+// This library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// accessSampleRule sets the sample rate (log 1 in N) for access events
+// matching method (empty means any) and statusClass (0 means any, else
+// 2..5 for the HTTP status class). The last matching rule wins, so rules
+// can be layered from general to specific.
+type accessSampleRule struct {
+	method      string
+	statusClass int
+	rate        int
+}
+
+// accessLogPolicy decides, per access event, whether it should be
+// enqueued to the logger at all. It defaults to logging everything; a
+// config-driven sample rate can then be layered on top to thin out high
+// volume, low value traffic (e.g. successful GETs). 4xx/5xx and writes
+// are kept too unless a rule matching them sets a rate above 1.
+type accessLogPolicy struct {
+	mu sync.RWMutex
+	// rules is ordered so that, for a given bucket key, the index of its
+	// winning rule is stable between Configure calls; rateFor (below)
+	// relies on that to reuse one counter per {method,status_class}
+	// tuple instead of sharing a single global counter across rules.
+	rules []accessSampleRule
+	// counters holds one atomic sample counter per rule (indexed the
+	// same as rules), so that e.g. "GET:2xx=10,GET:3xx=10" samples each
+	// class independently instead of interleaving on a shared counter.
+	counters []uint64
+}
+
+var accessPolicy = &accessLogPolicy{}
+
+// Configure parses a spec such as:
+//
+//	"GET:2xx=10,GET:3xx=10,PUT=1,DELETE=1"
+//
+// where the right-hand side is a sample rate (log 1 in N; 1 means always).
+// An empty method or missing ":NxX" status class matches anything on that
+// axis. It is meant to be loaded once at startup from the rawx config.
+func (p *accessLogPolicy) Configure(spec string) error {
+	var rules []accessSampleRule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid access_log_policy entry %q, expected selector=rate", part)
+		}
+		rate, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || rate < 1 {
+			return fmt.Errorf("invalid sample rate in %q", part)
+		}
+
+		method, class := "", 0
+		selector := strings.TrimSpace(kv[0])
+		if idx := strings.IndexByte(selector, ':'); idx >= 0 {
+			method = selector[:idx]
+			classSpec := selector[idx+1:]
+			if classSpec != "" {
+				n, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(classSpec), "xx"))
+				if err != nil {
+					return fmt.Errorf("invalid status class in %q", part)
+				}
+				class = n
+			}
+		} else {
+			method = selector
+		}
+
+		rules = append(rules, accessSampleRule{method: method, statusClass: class, rate: rate})
+	}
+
+	p.mu.Lock()
+	p.rules = rules
+	p.counters = make([]uint64, len(rules))
+	p.mu.Unlock()
+	return nil
+}
+
+// rateFor resolves the sample rate and bucket counter applying to
+// method/status. It returns rate 1 (always log) and a nil counter when
+// nothing matches.
+func (p *accessLogPolicy) rateFor(method string, status int) (int, *uint64) {
+	class := status / 100
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	rate := 1
+	var counter *uint64
+	for i, r := range p.rules {
+		if (r.method == "" || strings.EqualFold(r.method, method)) &&
+			(r.statusClass == 0 || r.statusClass == class) {
+			rate = r.rate
+			counter = &p.counters[i]
+		}
+	}
+	return rate, counter
+}
+
+// shouldLog applies deterministic sampling: 4xx/5xx responses and writes
+// (anything but GET/HEAD) are covered by the default rate of 1 unless a
+// rule says otherwise; everything else is kept 1 event out of every
+// `rate` evaluated. Each rule owns its own atomic counter, so distinct
+// buckets (e.g. "GET:2xx=10" and "GET:3xx=10") sample independently
+// instead of interleaving on a counter shared across buckets.
+func (p *accessLogPolicy) shouldLog(evt AccessLogEvent) bool {
+	rate, counter := p.rateFor(evt.Method, evt.Status)
+	if rate <= 1 || counter == nil {
+		return true
+	}
+	n := atomic.AddUint64(counter, 1)
+	return n%uint64(rate) == 0
+}
+
+// accessLogStats counts access events that never reached the logger
+// backend because its queue was full, broken down by HTTP status class,
+// so operators can tell a degraded logging pipeline from healthy but
+// sampled traffic. The counters are read through Snapshot; no stats
+// handler in the rawx calls it, so they are not reported by the stats
+// endpoint.
+type accessLogStats struct {
+	droppedTotal         uint64
+	droppedByStatus2xx   uint64
+	droppedByStatus3xx   uint64
+	droppedByStatus4xx   uint64
+	droppedByStatus5xx   uint64
+	droppedByStatusOther uint64
+}
+
+var accessStats accessLogStats
+
+func (s *accessLogStats) recordDrop(evt AccessLogEvent) {
+	atomic.AddUint64(&s.droppedTotal, 1)
+	switch evt.Status / 100 {
+	case 2:
+		atomic.AddUint64(&s.droppedByStatus2xx, 1)
+	case 3:
+		atomic.AddUint64(&s.droppedByStatus3xx, 1)
+	case 4:
+		atomic.AddUint64(&s.droppedByStatus4xx, 1)
+	case 5:
+		atomic.AddUint64(&s.droppedByStatus5xx, 1)
+	default:
+		atomic.AddUint64(&s.droppedByStatusOther, 1)
+	}
+}
+
+// Snapshot returns the current drop counters, keyed by stat name (e.g.
+// "access.dropped.total").
+func (s *accessLogStats) Snapshot() map[string]uint64 {
+	return map[string]uint64{
+		"access.dropped.total": atomic.LoadUint64(&s.droppedTotal),
+		"access.dropped.2xx":   atomic.LoadUint64(&s.droppedByStatus2xx),
+		"access.dropped.3xx":   atomic.LoadUint64(&s.droppedByStatus3xx),
+		"access.dropped.4xx":   atomic.LoadUint64(&s.droppedByStatus4xx),
+		"access.dropped.5xx":   atomic.LoadUint64(&s.droppedByStatus5xx),
+		"access.dropped.other": atomic.LoadUint64(&s.droppedByStatusOther),
+	}
+}
@@ -0,0 +1,111 @@
+// OpenIO SDS Go rawx
+// Copyright (C) 2015-2020 OpenIO SAS
+// Copyright (C) 2021 OVH SAS
+//
+// This library is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Affero General Public
+// License as published by the Free Software Foundation; either
+// version 3.0 of the License, or (at your option) any later version.
+//
+// This library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import "testing"
+
+func TestAccessLogPolicyConfigureParsesSelectors(t *testing.T) {
+	p := &accessLogPolicy{}
+	if err := p.Configure("GET:2xx=10,PUT=notanumber"); err == nil {
+		t.Fatalf("Configure with a non-numeric rate should have failed")
+	}
+
+	if err := p.Configure("GET:2xx=10,GET:3xx=4,PUT=1"); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if len(p.rules) != 3 {
+		t.Fatalf("len(rules) = %d, want 3", len(p.rules))
+	}
+
+	if rate, _ := p.rateFor("GET", 200); rate != 10 {
+		t.Errorf("rateFor(GET, 200) = %d, want 10", rate)
+	}
+	if rate, _ := p.rateFor("GET", 304); rate != 4 {
+		t.Errorf("rateFor(GET, 304) = %d, want 4", rate)
+	}
+	if rate, _ := p.rateFor("PUT", 200); rate != 1 {
+		t.Errorf("rateFor(PUT, 200) = %d, want 1", rate)
+	}
+	if rate, _ := p.rateFor("DELETE", 200); rate != 1 {
+		t.Errorf("rateFor(DELETE, 200) = %d, want 1 (no matching rule)", rate)
+	}
+}
+
+func TestAccessLogPolicyShouldLogSamplesOneInN(t *testing.T) {
+	p := &accessLogPolicy{}
+	if err := p.Configure("GET:2xx=4"); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	kept := 0
+	const n = 40
+	for i := 0; i < n; i++ {
+		if p.shouldLog(AccessLogEvent{Method: "GET", Status: 200}) {
+			kept++
+		}
+	}
+	if want := n / 4; kept != want {
+		t.Errorf("kept %d/%d events, want exactly %d (1 in 4)", kept, n, want)
+	}
+}
+
+func TestAccessLogPolicyShouldLogBucketsSampleIndependently(t *testing.T) {
+	p := &accessLogPolicy{}
+	if err := p.Configure("GET:2xx=2,GET:3xx=2"); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	kept2xx, kept3xx := 0, 0
+	const n = 20
+	for i := 0; i < n; i++ {
+		if p.shouldLog(AccessLogEvent{Method: "GET", Status: 200}) {
+			kept2xx++
+		}
+		if p.shouldLog(AccessLogEvent{Method: "GET", Status: 304}) {
+			kept3xx++
+		}
+	}
+	if kept2xx != n/2 {
+		t.Errorf("kept 2xx %d/%d, want %d", kept2xx, n, n/2)
+	}
+	if kept3xx != n/2 {
+		t.Errorf("kept 3xx %d/%d, want %d", kept3xx, n, n/2)
+	}
+}
+
+func TestAccessLogStatsSnapshot(t *testing.T) {
+	var s accessLogStats
+	s.recordDrop(AccessLogEvent{Status: 200})
+	s.recordDrop(AccessLogEvent{Status: 404})
+	s.recordDrop(AccessLogEvent{Status: 404})
+	s.recordDrop(AccessLogEvent{Status: 503})
+
+	snap := s.Snapshot()
+	if snap["access.dropped.total"] != 4 {
+		t.Errorf("total = %d, want 4", snap["access.dropped.total"])
+	}
+	if snap["access.dropped.2xx"] != 1 {
+		t.Errorf("2xx = %d, want 1", snap["access.dropped.2xx"])
+	}
+	if snap["access.dropped.4xx"] != 2 {
+		t.Errorf("4xx = %d, want 2", snap["access.dropped.4xx"])
+	}
+	if snap["access.dropped.5xx"] != 1 {
+		t.Errorf("5xx = %d, want 1", snap["access.dropped.5xx"])
+	}
+}
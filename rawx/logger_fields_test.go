@@ -0,0 +1,78 @@
+// OpenIO SDS Go rawx
+// Copyright (C) 2015-2020 OpenIO SAS
+// Copyright (C) 2021 OVH SAS
+//
+// This library is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Affero General Public
+// License as published by the Free Software Foundation; either
+// version 3.0 of the License, or (at your option) any later version.
+//
+// This library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// These tests exercise the FieldLogger API directly, against a nil
+// *rawxRequest, since the PUT/GET/DELETE handlers that would otherwise be
+// its real callers aren't part of this tree.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFieldLoggerWithFieldIsImmutable(t *testing.T) {
+	base := (*rawxRequest)(nil).Log().WithField("chunk", "c0")
+	derived := base.WithField("offset", 42)
+
+	if _, ok := base.fields["offset"]; ok {
+		t.Fatalf("WithField mutated the base logger's field set: %v", base.fields)
+	}
+	if len(base.fields) != 1 || base.fields["chunk"] != "c0" {
+		t.Fatalf("base fields = %v, want only chunk=c0", base.fields)
+	}
+	if len(derived.fields) != 2 || derived.fields["chunk"] != "c0" || derived.fields["offset"] != 42 {
+		t.Fatalf("derived fields = %v, want chunk=c0 and offset=42", derived.fields)
+	}
+}
+
+func TestEncodeFieldsLogfmtIsSortedByKey(t *testing.T) {
+	fields := map[string]interface{}{
+		"offset": 42,
+		"chunk":  "c0",
+	}
+	if got, want := encodeFieldsLogfmt(fields), `chunk=c0 offset=42`; got != want {
+		t.Errorf("encodeFieldsLogfmt(%v) = %q, want %q", fields, got, want)
+	}
+}
+
+func TestBuildLogRequestFieldsMergesFieldsForJSON(t *testing.T) {
+	inv := LogRequestTemplateInventory{
+		Message: "done",
+		Fields: map[string]interface{}{
+			"chunk":  "c0",
+			"offset": 42,
+		},
+	}
+
+	out := buildLogRequestFields(inv).encode(logRecordJSON)
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &decoded); err != nil {
+		t.Fatalf("json.Unmarshal(%q): %v", out, err)
+	}
+	if decoded["message"] != "done" {
+		t.Errorf("message = %v, want %q", decoded["message"], "done")
+	}
+	if decoded["chunk"] != "c0" {
+		t.Errorf("chunk = %v, want %q", decoded["chunk"], "c0")
+	}
+	if decoded["offset"] != float64(42) {
+		t.Errorf("offset = %v, want 42", decoded["offset"])
+	}
+}
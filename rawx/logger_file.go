@@ -0,0 +1,357 @@
+// OpenIO SDS Go rawx
+// Copyright (C) 2015-2020 OpenIO SAS
+// Copyright (C) 2021 OVH SAS
+//
+// This library is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Affero General Public
+// License as published by the Free Software Foundation; either
+// version 3.0 of the License, or (at your option) any later version.
+//
+// This library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// rotatingFile is an append-only log stream backed by a timestamped file
+// `<path>.<YYYYMMDD-HHMMSS>.log[.gz]`, with `<path>` kept as a symlink to
+// whichever file is currently being written, like klog's file backend.
+// It rotates when the file grows past maxSizeBytes, when the wallclock
+// day changes, or on an explicit reopen() (e.g. on SIGHUP), keeping at
+// most maxBackups rotated files not older than maxAge.
+type rotatingFile struct {
+	mu           sync.Mutex
+	path         string
+	maxSizeBytes int64
+	maxAge       time.Duration
+	maxBackups   int
+	compress     bool
+
+	f       *os.File
+	current string
+	size    int64
+	openDay int
+}
+
+func newRotatingFile(path string, maxSizeBytes int64, maxAge time.Duration, maxBackups int, compress bool) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:         path,
+		maxSizeBytes: maxSizeBytes,
+		maxAge:       maxAge,
+		maxBackups:   maxBackups,
+		compress:     compress,
+	}
+	if err := rf.mkdir(); err != nil {
+		return nil, err
+	}
+	if err := rf.openNewLocked(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) mkdir() error {
+	if err := os.MkdirAll(filepath.Dir(rf.path), 0755); err != nil {
+		return fmt.Errorf("mkdir for %s: %v", rf.path, err)
+	}
+	return nil
+}
+
+// openNewLocked creates a fresh timestamped file, points the `<path>`
+// symlink at it, and makes it the current file. The caller must hold
+// rf.mu.
+func (rf *rotatingFile) openNewLocked() error {
+	now := time.Now()
+	name := fmt.Sprintf("%s.%s.log", rf.path, now.Format("20060102-150405"))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", name, err)
+	}
+
+	tmpLink := rf.path + ".tmp"
+	os.Remove(tmpLink)
+	if err := os.Symlink(filepath.Base(name), tmpLink); err != nil {
+		f.Close()
+		return fmt.Errorf("symlink %s: %v", tmpLink, err)
+	}
+	if err := os.Rename(tmpLink, rf.path); err != nil {
+		f.Close()
+		return fmt.Errorf("install symlink %s: %v", rf.path, err)
+	}
+
+	rf.f = f
+	rf.current = name
+	rf.size = 0
+	rf.openDay = now.YearDay()
+	return nil
+}
+
+// write appends one record, rotating first if the size or day based
+// policy requires it.
+func (rf *rotatingFile) write(line string) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		if err := rf.openNewLocked(); err != nil {
+			return err
+		}
+	}
+
+	needRotate := time.Now().YearDay() != rf.openDay
+	if rf.maxSizeBytes > 0 && rf.size+int64(len(line))+1 > rf.maxSizeBytes {
+		needRotate = true
+	}
+	if needRotate && rf.size > 0 {
+		if err := rf.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	n, err := rf.f.WriteString(line + "\n")
+	rf.size += int64(n)
+	return err
+}
+
+// rotateLocked closes and archives the current file (gzipping it if
+// configured), prunes old backups, then opens a new current file. The
+// caller must hold rf.mu.
+func (rf *rotatingFile) rotateLocked() error {
+	closing := rf.current
+	if rf.f != nil {
+		rf.f.Close()
+		rf.f = nil
+	}
+
+	if rf.compress && closing != "" {
+		if err := gzipFile(closing); err == nil {
+			os.Remove(closing)
+		}
+	}
+
+	rf.pruneBackupsLocked()
+	return rf.openNewLocked()
+}
+
+// pruneBackupsLocked removes rotated files beyond maxBackups and ones
+// older than maxAge. The caller must hold rf.mu.
+func (rf *rotatingFile) pruneBackupsLocked() {
+	if rf.maxBackups <= 0 && rf.maxAge <= 0 {
+		return
+	}
+	dir := filepath.Dir(rf.path)
+	base := filepath.Base(rf.path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	var backups []string
+	for _, e := range entries {
+		name := e.Name()
+		if name == base || name == base+".tmp" {
+			continue
+		}
+		// Only this stream's timestamped files: with separate streams,
+		// "<path>.info" and "<path>.err" share the "<path>." prefix.
+		rest := strings.TrimPrefix(name, base+".")
+		if rest != name && rest != "" && rest[0] >= '0' && rest[0] <= '9' {
+			backups = append(backups, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(backups)
+
+	if rf.maxAge > 0 {
+		cutoff := time.Now().Add(-rf.maxAge)
+		kept := backups[:0]
+		for _, b := range backups {
+			if st, err := os.Stat(b); err == nil && st.ModTime().Before(cutoff) {
+				os.Remove(b)
+				continue
+			}
+			kept = append(kept, b)
+		}
+		backups = kept
+	}
+
+	if rf.maxBackups > 0 && len(backups) > rf.maxBackups {
+		for _, b := range backups[:len(backups)-rf.maxBackups] {
+			os.Remove(b)
+		}
+	}
+}
+
+// reopen forces a rotation to a fresh file, for compatibility with
+// external rotators (e.g. logrotate's postrotate hook sending SIGHUP).
+func (rf *rotatingFile) reopen() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.rotateLocked()
+}
+
+func (rf *rotatingFile) close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
+
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gw := gzip.NewWriter(out)
+	if _, err := io.Copy(gw, in); err != nil {
+		gw.Close()
+		return err
+	}
+	return gw.Close()
+}
+
+// FileLogger is an alternative to SysLogger that writes to plain,
+// self-rotating files instead of syslog. It reuses the bounded queue +
+// goroutine model from SysLogger for the access stream, since access
+// logging is by far the highest volume one.
+type FileLogger struct {
+	access *rotatingFile
+	info   *rotatingFile
+	err    *rotatingFile
+
+	queue         chan string
+	wg            sync.WaitGroup
+	alertThrottle PeriodicThrottle
+
+	sighup chan os.Signal
+}
+
+// InitFileLogger starts a FileLogger writing under basePath. When
+// separateStreams is true, access/info/error each get their own stream
+// (basePath, basePath+".info", basePath+".err"); otherwise all three
+// share a single file, each line tagged with its severity.
+func InitFileLogger(basePath string, maxSizeBytes int64, maxAge time.Duration, maxBackups int, compress bool, separateStreams bool) error {
+	access, err := newRotatingFile(basePath, maxSizeBytes, maxAge, maxBackups, compress)
+	if err != nil {
+		return err
+	}
+
+	info, errf := access, access
+	if separateStreams {
+		if info, err = newRotatingFile(basePath+".info", maxSizeBytes, maxAge, maxBackups, compress); err != nil {
+			return err
+		}
+		if errf, err = newRotatingFile(basePath+".err", maxSizeBytes, maxAge, maxBackups, compress); err != nil {
+			return err
+		}
+	}
+
+	l := &FileLogger{
+		access:        access,
+		info:          info,
+		err:           errf,
+		queue:         make(chan string, configAccessLogQueueDefaultLength),
+		alertThrottle: PeriodicThrottle{period: 1000000000},
+	}
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for line := range l.queue {
+			if err := l.access.write(line); err != nil {
+				log.Printf("Error while writing access log: %v", err)
+			}
+		}
+	}()
+
+	l.sighup = make(chan os.Signal, 1)
+	signal.Notify(l.sighup, syscall.SIGHUP)
+	go func() {
+		for range l.sighup {
+			l.reopenAll()
+		}
+	}()
+
+	logger = l
+	return nil
+}
+
+func (l *FileLogger) reopenAll() {
+	l.access.reopen()
+	if l.info != l.access {
+		l.info.reopen()
+	}
+	if l.err != l.access && l.err != l.info {
+		l.err.reopen()
+	}
+}
+
+func (l *FileLogger) writeAccess(m string) bool {
+	select {
+	case l.queue <- m:
+		return true
+	default:
+		if l.alertThrottle.Ok() {
+			LogWarning("file access log clogged")
+		}
+		return false
+	}
+}
+
+func (l *FileLogger) writeInfo(m string) {
+	if l.info == l.access {
+		m = "INF " + m
+	}
+	l.info.write(m)
+}
+
+func (l *FileLogger) writeError(m string) {
+	if l.err == l.access {
+		m = "ERR " + m
+	}
+	l.err.write(m)
+}
+
+func (l *FileLogger) close() {
+	signal.Stop(l.sighup)
+	close(l.sighup)
+	close(l.queue)
+	l.wg.Wait()
+	l.access.close()
+	if l.info != l.access {
+		l.info.close()
+	}
+	if l.err != l.access && l.err != l.info {
+		l.err.close()
+	}
+}
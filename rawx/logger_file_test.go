@@ -0,0 +1,112 @@
+// OpenIO SDS Go rawx
+// Copyright (C) 2015-2020 OpenIO SAS
+// Copyright (C) 2021 OVH SAS
+//
+// This library is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Affero General Public
+// License as published by the Free Software Foundation; either
+// version 3.0 of the License, or (at your option) any later version.
+//
+// This library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileOpenNewNamesAndSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "access.log")
+
+	rf, err := newRotatingFile(base, 0, 0, 0, false)
+	if err != nil {
+		t.Fatalf("newRotatingFile: %v", err)
+	}
+	defer rf.close()
+
+	st, err := os.Lstat(base)
+	if err != nil {
+		t.Fatalf("Lstat(%s): %v", base, err)
+	}
+	if st.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("%s is not a symlink", base)
+	}
+
+	target, err := os.Readlink(base)
+	if err != nil {
+		t.Fatalf("Readlink(%s): %v", base, err)
+	}
+	if filepath.Base(rf.current) != target {
+		t.Errorf("symlink target = %q, want %q", target, filepath.Base(rf.current))
+	}
+	if filepath.Dir(rf.current) != dir {
+		t.Errorf("current file dir = %q, want %q", filepath.Dir(rf.current), dir)
+	}
+}
+
+func TestRotatingFilePruneBackupsByCount(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "access.log")
+
+	rf := &rotatingFile{path: base, maxBackups: 2}
+	names := []string{
+		base + ".20260101-000000.log",
+		base + ".20260102-000000.log",
+		base + ".20260103-000000.log",
+	}
+	for _, n := range names {
+		if err := os.WriteFile(n, []byte("x"), 0644); err != nil {
+			t.Fatalf("WriteFile(%s): %v", n, err)
+		}
+	}
+
+	rf.pruneBackupsLocked()
+
+	for _, n := range names[:1] {
+		if _, err := os.Stat(n); !os.IsNotExist(err) {
+			t.Errorf("%s should have been pruned", n)
+		}
+	}
+	for _, n := range names[1:] {
+		if _, err := os.Stat(n); err != nil {
+			t.Errorf("%s should have been kept: %v", n, err)
+		}
+	}
+}
+
+func TestRotatingFilePruneBackupsByAge(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "access.log")
+
+	rf := &rotatingFile{path: base, maxAge: time.Hour}
+	old := base + ".20200101-000000.log"
+	recent := base + ".20260101-000000.log"
+	for _, n := range []string{old, recent} {
+		if err := os.WriteFile(n, []byte("x"), 0644); err != nil {
+			t.Fatalf("WriteFile(%s): %v", n, err)
+		}
+	}
+	oldTime := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(old, oldTime, oldTime); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	rf.pruneBackupsLocked()
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("%s should have been pruned as too old", old)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("%s should have been kept: %v", recent, err)
+	}
+}
@@ -0,0 +1,230 @@
+// OpenIO SDS Go rawx
+// Copyright (C) 2015-2020 OpenIO SAS
+// Copyright (C) 2021 OVH SAS
+//
+// This library is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Affero General Public
+// License as published by the Free Software Foundation; either
+// version 3.0 of the License, or (at your option) any later version.
+//
+// This library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// logRecordFormat selects how a formatted log line is serialized, on top of
+// the existing text/template rendering. The fields carried are always the
+// same ones as the free-text format (pid, severity, local, peer, method,
+// status, bytes_in, bytes_out, time_spent, ttfb, reqid, path, tls, message),
+// only the encoding changes.
+type logRecordFormat int
+
+const (
+	logRecordText logRecordFormat = iota
+	logRecordLogfmt
+	logRecordJSON
+)
+
+// logOutputFormat is the format used by writeLogFmt, writeLogRequestFmt and
+// AccessLogEvent.String(). It defaults to the historical template-based
+// text format and is meant to be set once at startup from the
+// `log_format` config key (e.g. "text", "logfmt" or "json").
+var logOutputFormat = logRecordText
+
+// ParseLogOutputFormat maps a config value to a logRecordFormat. It returns
+// an error for anything else so a typo in the config surfaces immediately
+// instead of silently falling back to plain text.
+func ParseLogOutputFormat(name string) (logRecordFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "text":
+		return logRecordText, nil
+	case "logfmt":
+		return logRecordLogfmt, nil
+	case "json":
+		return logRecordJSON, nil
+	default:
+		return logRecordText, fmt.Errorf("unknown log_format %q", name)
+	}
+}
+
+// SetLogOutputFormat parses and installs the log output format. It is meant
+// to be called once, at startup, before InitStderrLogger/InitSysLogger.
+func SetLogOutputFormat(name string) error {
+	f, err := ParseLogOutputFormat(name)
+	if err != nil {
+		return err
+	}
+	logOutputFormat = f
+	return nil
+}
+
+// logField is a single key/value pair, kept ordered so the logfmt encoder
+// always emits keys in the order they were added. The JSON encoder goes
+// through a map, so encoding/json emits its keys sorted by name: also
+// stable, but not the logfmt order.
+type logField struct {
+	key   string
+	value interface{}
+}
+
+type logFields []logField
+
+func (f *logFields) add(key string, value interface{}) {
+	*f = append(*f, logField{key: key, value: value})
+}
+
+func (f logFields) encode(format logRecordFormat) string {
+	switch format {
+	case logRecordJSON:
+		return f.encodeJSON()
+	default:
+		return f.encodeLogfmt()
+	}
+}
+
+func (f logFields) encodeJSON() string {
+	obj := make(map[string]interface{}, len(f))
+	for _, kv := range f {
+		obj[kv.key] = kv.value
+	}
+	out, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Sprintf(`{"error":"log encoding failed: %v"}`, err)
+	}
+	return string(out)
+}
+
+func (f logFields) encodeLogfmt() string {
+	var out bytes.Buffer
+	for i, kv := range f {
+		if i > 0 {
+			out.WriteByte(' ')
+		}
+		out.WriteString(kv.key)
+		out.WriteByte('=')
+		out.WriteString(logfmtValue(kv.value))
+	}
+	return out.String()
+}
+
+// logfmtValue renders v the way logfmt expects: quoted (with escaping) as
+// soon as it contains a space, an equal sign, a quote or a newline.
+func logfmtValue(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return logfmtQuoteIfNeeded(t)
+	case bool:
+		return strconv.FormatBool(t)
+	case int:
+		return strconv.Itoa(t)
+	case int64:
+		return strconv.FormatInt(t, 10)
+	case uint64:
+		return strconv.FormatUint(t, 10)
+	default:
+		return logfmtQuoteIfNeeded(fmt.Sprintf("%v", t))
+	}
+}
+
+func logfmtQuoteIfNeeded(s string) string {
+	if s == "" {
+		return `""`
+	}
+	if !strings.ContainsAny(s, " =\"\n\t") {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// buildLogFields maps a LogTemplateInventory to the stable key order used
+// by the logfmt encoder.
+func buildLogFields(inv LogTemplateInventory) logFields {
+	var f logFields
+	f.add("pid", inv.Pid)
+	f.add("severity", inv.Severity)
+	f.add("message", inv.Message)
+	return f
+}
+
+// buildLogRequestFields maps a LogRequestTemplateInventory to the stable
+// key order used by the logfmt encoder. Any attached
+// FieldLogger fields are merged in after message, sorted by key so the
+// output stays deterministic.
+func buildLogRequestFields(inv LogRequestTemplateInventory) logFields {
+	var f logFields
+	f.add("pid", inv.Pid)
+	f.add("severity", inv.Severity)
+	f.add("local", inv.Local)
+	f.add("peer", inv.Peer)
+	f.add("method", inv.Method)
+	f.add("reqid", inv.ReqId)
+	f.add("path", inv.Path)
+	f.add("tls", inv.TLS)
+	f.add("message", inv.Message)
+	for _, k := range sortedFieldKeys(inv.Fields) {
+		f.add(k, inv.Fields[k])
+	}
+	return f
+}
+
+// sortedFieldKeys returns the keys of fields in sorted order, so repeated
+// renderings of the same field set are byte-identical.
+func sortedFieldKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// encodeFieldsLogfmt renders fields as a sequence of "k=v" pairs, in the
+// same escaping style as the logfmt encoder, for appending to a free-text
+// message.
+func encodeFieldsLogfmt(fields map[string]interface{}) string {
+	var f logFields
+	for _, k := range sortedFieldKeys(fields) {
+		f.add(k, fields[k])
+	}
+	return f.encodeLogfmt()
+}
+
+// buildAccessLogFields maps an AccessLogEvent to the stable key order used
+// by the logfmt encoder. "type" and "severity" are
+// added on top of the AccessLogEvent fields themselves: the text format
+// encodes the same information as the literal "access INF" in the
+// template, and a structured record needs it spelled out as a field to
+// stay distinguishable from info/error records and to carry severity,
+// which is part of this format's required field list.
+func buildAccessLogFields(evt AccessLogEvent) logFields {
+	var f logFields
+	f.add("pid", evt.Pid)
+	f.add("type", "access")
+	f.add("severity", "INF")
+	f.add("local", evt.Local)
+	f.add("peer", evt.Peer)
+	f.add("method", evt.Method)
+	f.add("status", evt.Status)
+	f.add("bytes_in", evt.BytesIn)
+	f.add("bytes_out", evt.BytesOut)
+	f.add("time_spent", evt.TimeSpent)
+	f.add("ttfb", evt.TTFB)
+	f.add("reqid", evt.ReqId)
+	f.add("path", evt.Path)
+	f.add("tls", evt.TLS)
+	return f
+}
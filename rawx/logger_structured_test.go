@@ -0,0 +1,68 @@
+// OpenIO SDS Go rawx
+// Copyright (C) 2015-2020 OpenIO SAS
+// Copyright (C) 2021 OVH SAS
+//
+// This library is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Affero General Public
+// License as published by the Free Software Foundation; either
+// version 3.0 of the License, or (at your option) any later version.
+//
+// This library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import "testing"
+
+func TestLogfmtQuoteIfNeeded(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"", `""`},
+		{"simple", "simple"},
+		{"has space", `"has space"`},
+		{"has=equals", `"has=equals"`},
+		{`has"quote`, `"has\"quote"`},
+		{"has\nnewline", `"has\nnewline"`},
+	}
+	for _, c := range cases {
+		if got := logfmtQuoteIfNeeded(c.in); got != c.want {
+			t.Errorf("logfmtQuoteIfNeeded(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestLogFieldsEncodeLogfmtOrderAndEscaping(t *testing.T) {
+	var f logFields
+	f.add("pid", 42)
+	f.add("path", "/has space")
+	f.add("ok", true)
+
+	got := f.encodeLogfmt()
+	want := `pid=42 path="/has space" ok=true`
+	if got != want {
+		t.Errorf("encodeLogfmt() = %q, want %q", got, want)
+	}
+}
+
+func TestBuildAccessLogFieldsCarriesTypeAndSeverity(t *testing.T) {
+	evt := AccessLogEvent{Status: 200, Method: "GET"}
+	fields := buildAccessLogFields(evt)
+
+	seen := map[string]interface{}{}
+	for _, kv := range fields {
+		seen[kv.key] = kv.value
+	}
+	if seen["type"] != "access" {
+		t.Errorf("type = %v, want %q", seen["type"], "access")
+	}
+	if seen["severity"] != "INF" {
+		t.Errorf("severity = %v, want %q", seen["severity"], "INF")
+	}
+}
@@ -0,0 +1,91 @@
+// OpenIO SDS Go rawx
+// Copyright (C) 2015-2020 OpenIO SAS
+// Copyright (C) 2021 OVH SAS
+//
+// This library is free software; you can redistribute it and/or
+// modify it under the terms of the GNU Affero General Public
+// License as published by the Free Software Foundation; either
+// version 3.0 of the License, or (at your option) any later version.
+//
+// This library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+// Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public
+// License along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// adminHandleVmodule isn't registered on any mux in this tree: there is no
+// admin server here to register it on. These tests drive it directly with
+// httptest instead, since that's the only exercise it can get here.
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandleVmoduleGetReportsCurrentSpec(t *testing.T) {
+	saved := verbosity
+	defer func() { verbosity = saved }()
+	verbosity = &VerbosityFilter{}
+	if err := verbosity.SetModules("chunk*=2"); err != nil {
+		t.Fatalf("SetModules: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/admin/vmodule", nil)
+	rec := httptest.NewRecorder()
+	adminHandleVmodule(rec, req)
+
+	if rec.Code != 200 {
+		t.Fatalf("GET status = %d, want 200", rec.Code)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != "chunk*=2" {
+		t.Errorf("GET body = %q, want %q", got, "chunk*=2")
+	}
+}
+
+func TestAdminHandleVmodulePostAppliesSpec(t *testing.T) {
+	saved := verbosity
+	defer func() { verbosity = saved }()
+	verbosity = &VerbosityFilter{}
+
+	req := httptest.NewRequest("POST", "/admin/vmodule", strings.NewReader("spec=handler_put%3D3"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	adminHandleVmodule(rec, req)
+
+	if rec.Code != 204 {
+		t.Fatalf("POST status = %d, want 204", rec.Code)
+	}
+	if got := verbosity.levelFor("handler_put.go"); got != 3 {
+		t.Errorf("levelFor(handler_put.go) = %d, want 3", got)
+	}
+}
+
+func TestAdminHandleVmodulePostRejectsInvalidSpec(t *testing.T) {
+	saved := verbosity
+	defer func() { verbosity = saved }()
+	verbosity = &VerbosityFilter{}
+
+	req := httptest.NewRequest("POST", "/admin/vmodule", strings.NewReader("spec=broken"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	adminHandleVmodule(rec, req)
+
+	if rec.Code != 400 {
+		t.Fatalf("POST with invalid spec status = %d, want 400", rec.Code)
+	}
+}
+
+func TestAdminHandleVmoduleRejectsOtherMethods(t *testing.T) {
+	req := httptest.NewRequest("DELETE", "/admin/vmodule", nil)
+	rec := httptest.NewRecorder()
+	adminHandleVmodule(rec, req)
+
+	if rec.Code != 405 {
+		t.Fatalf("DELETE status = %d, want 405", rec.Code)
+	}
+}